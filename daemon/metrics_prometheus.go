@@ -0,0 +1,95 @@
+//go:build prometheus
+// +build prometheus
+
+package daemon
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics registers a set of Prometheus collectors describing the
+// pool's behavior under reg, using namespace as their metric namespace,
+// and returns a DriverPoolOption wiring them into the pool. It is only
+// available when this package is built with the "prometheus" tag, so
+// callers that don't want the dependency aren't forced to pull it in.
+func WithMetrics(reg prometheus.Registerer, namespace string) DriverPoolOption {
+	m := newPrometheusMetrics(namespace)
+	reg.MustRegister(
+		m.instances,
+		m.inFlight,
+		m.restarts,
+		m.executeErrors,
+		m.executeDuration,
+		m.lastScale,
+	)
+
+	return func(p *DriverPool) {
+		p.metrics = m
+	}
+}
+
+type prometheusMetrics struct {
+	instances       prometheus.Gauge
+	inFlight        prometheus.Gauge
+	restarts        prometheus.Counter
+	executeErrors   *prometheus.CounterVec
+	executeDuration prometheus.Histogram
+	lastScale       prometheus.Gauge
+}
+
+func newPrometheusMetrics(namespace string) *prometheusMetrics {
+	return &prometheusMetrics{
+		instances: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "instances",
+			Help:      "Current number of live driver instances.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "in_flight",
+			Help:      "Number of Execute calls currently running.",
+		}),
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "driver_restarts_total",
+			Help:      "Number of driver instances recycled by the recovery loop after being found stopped.",
+		}),
+		executeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "execute_errors_total",
+			Help:      "Number of Execute/ExecuteCtx calls that returned an error, by cause.",
+		}, []string{"cause"}),
+		executeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "execute_duration_seconds",
+			Help:      "Wall time of Execute/ExecuteCtx calls.",
+		}),
+		lastScale: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "driver_pool",
+			Name:      "last_scale",
+			Help:      "Most recent output of the pool's ScalingPolicy.",
+		}),
+	}
+}
+
+func (m *prometheusMetrics) SetInstances(n int) { m.instances.Set(float64(n)) }
+func (m *prometheusMetrics) SetInFlight(n int)  { m.inFlight.Set(float64(n)) }
+func (m *prometheusMetrics) IncRestarts()       { m.restarts.Inc() }
+
+func (m *prometheusMetrics) IncExecuteError(cause string) {
+	m.executeErrors.WithLabelValues(cause).Inc()
+}
+
+func (m *prometheusMetrics) ObserveExecuteDuration(d time.Duration) {
+	m.executeDuration.Observe(d.Seconds())
+}
+
+func (m *prometheusMetrics) SetLastScale(n int) { m.lastScale.Set(float64(n)) }
@@ -0,0 +1,42 @@
+//go:build prometheus
+// +build prometheus
+
+package daemon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriverPool_Metrics(t *testing.T) {
+	require := require.New(t)
+
+	reg := prometheus.NewRegistry()
+	dp := NewDriverPool(newMockDriver, WithMetrics(reg, "bblfshd_test"))
+	m := dp.metrics.(*prometheusMetrics)
+
+	require.NoError(dp.Start())
+	require.Equal(1.0, testutil.ToFloat64(m.instances))
+
+	require.NoError(dp.Execute(func(Driver) error { return nil }))
+	require.Equal(1, testutil.CollectAndCount(m.executeDuration))
+	require.Equal(0.0, testutil.ToFloat64(m.executeErrors.WithLabelValues("driver_error")))
+
+	require.EqualError(dp.Execute(func(Driver) error { return fmt.Errorf("boom") }), "boom")
+	require.Equal(1.0, testutil.ToFloat64(m.executeErrors.WithLabelValues("driver_error")))
+
+	require.NoError(dp.Execute(func(d Driver) error {
+		d.(*mockDriver).MockStatus = libcontainer.Stopped
+		return nil
+	}))
+	require.NoError(dp.Execute(func(Driver) error { return nil }))
+	require.Equal(1.0, testutil.ToFloat64(m.restarts))
+
+	require.NoError(dp.Stop())
+	require.Equal(0.0, testutil.ToFloat64(m.instances))
+}
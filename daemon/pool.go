@@ -0,0 +1,548 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"gopkg.in/bblfsh/sdk.v1/protocol"
+)
+
+const (
+	// scaleDownInterval is how often the pool checks whether idle driver
+	// instances can be retired.
+	scaleDownInterval = 250 * time.Millisecond
+	// wakeupInterval is how often the background loop nudges any slow
+	// waiters parked in Execute, guarding against a missed cond.Broadcast
+	// when a driver is returned faster than a waiter can be woken.
+	wakeupInterval = 2 * time.Second
+)
+
+// Error is a sentinel error that can be compared with Is, mirroring the
+// standard library's errors.Is convention without requiring callers to
+// import the errors package for a simple identity check.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Is reports whether err is this sentinel error.
+func (e Error) Is(err error) bool {
+	return err == error(e)
+}
+
+const (
+	// ErrPoolClosed is returned once the pool has been stopped.
+	ErrPoolClosed = Error("pool closed")
+	// ErrPoolAtCapacity is returned by ExecuteCtx when a call parked
+	// waiting for a free driver is cancelled, or its context deadline
+	// expires, before a driver becomes available.
+	ErrPoolAtCapacity = Error("pool at capacity")
+	// ErrPoolDraining is returned to new Execute/ExecuteCtx callers once
+	// Drain has been called; callbacks already in flight are left to
+	// finish.
+	ErrPoolDraining = Error("pool draining")
+)
+
+// DefaultDrainTimeout bounds how long Stop waits for in-flight Execute
+// calls to finish before force-stopping their drivers.
+const DefaultDrainTimeout = 30 * time.Second
+
+// Driver represents a running driver instance, backed by a container.
+type Driver interface {
+	Start() error
+	Stop() error
+	Status() (libcontainer.Status, error)
+	Service() protocol.ProtocolServiceClient
+}
+
+// DriverInstance creates a new Driver, ready to be started.
+type DriverInstance func() (Driver, error)
+
+// Callback is executed against a Driver borrowed from a DriverPool.
+type Callback func(Driver) error
+
+// Stats is a snapshot of a DriverPool's internal counters, useful for
+// diagnosing pool contention.
+type Stats struct {
+	// Instances is the current number of live driver instances.
+	Instances int
+	// InFlight is the number of Execute calls currently running.
+	InFlight int
+	// SlowWaiters is the number of goroutines currently parked in
+	// Execute/ExecuteCtx waiting for a driver to become available.
+	SlowWaiters int
+}
+
+// driverCount is a small mutex-guarded counter used to track the number
+// of live driver instances; it exists mainly so tests can read it without
+// reaching into the pool's own lock.
+type driverCount struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *driverCount) Value() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func (c *driverCount) add(delta int) {
+	c.mu.Lock()
+	c.n += delta
+	c.mu.Unlock()
+}
+
+// DriverPool controls the amount of running Driver instances needed for a
+// given language, scaling them up and down according to ScalingPolicy,
+// and multiplexes Execute calls onto them.
+type DriverPool struct {
+	// New creates a new Driver instance when the pool needs to grow or
+	// replace one that has stopped unexpectedly.
+	New DriverInstance
+	// ScalingPolicy decides how many driver instances the pool should
+	// have, given its current size and an observed load sample. It
+	// defaults to scaling between 1 and runtime.NumCPU() instances.
+	ScalingPolicy ScalingPolicy
+	// MaxConcurrent, if non-zero, is a hard cap on the number of Execute
+	// calls allowed to run at once, independent of how many driver
+	// instances the scaling policy allows. Callers beyond the cap block
+	// until room frees up. Zero means no additional cap is applied.
+	MaxConcurrent int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	closed   bool
+	draining bool
+
+	idle    []Driver
+	current []Driver
+
+	instances   *driverCount
+	inFlight    int
+	slowWaiters int32
+
+	stopLoop chan struct{}
+	loopDone chan struct{}
+
+	metrics poolMetrics
+}
+
+// DriverPoolOption configures optional DriverPool behavior at
+// construction time.
+type DriverPoolOption func(*DriverPool)
+
+// poolMetrics is the internal surface DriverPool needs from a metrics
+// backend. It exists so that a plain build of this package has no
+// dependency on any particular metrics library; see metrics_prometheus.go
+// (built with the "prometheus" tag) for a Prometheus-backed
+// implementation exposed through WithMetrics.
+type poolMetrics interface {
+	SetInstances(n int)
+	SetInFlight(n int)
+	IncRestarts()
+	IncExecuteError(cause string)
+	ObserveExecuteDuration(d time.Duration)
+	SetLastScale(n int)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) SetInstances(int)                     {}
+func (noopMetrics) SetInFlight(int)                      {}
+func (noopMetrics) IncRestarts()                         {}
+func (noopMetrics) IncExecuteError(string)               {}
+func (noopMetrics) ObserveExecuteDuration(time.Duration) {}
+func (noopMetrics) SetLastScale(int)                     {}
+
+// NewDriverPool creates a DriverPool that spawns new Driver instances
+// using new.
+func NewDriverPool(new DriverInstance, opts ...DriverPoolOption) *DriverPool {
+	p := &DriverPool{
+		New:           new,
+		ScalingPolicy: MinMax(1, runtime.NumCPU(), AIMD(1, 0.5)),
+		instances:     &driverCount{},
+		metrics:       noopMetrics{},
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Start creates the pool's first driver instance and starts the
+// background scaling loop.
+func (p *DriverPool) Start() error {
+	d, err := p.spawn()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.idle = append(p.idle, d)
+	p.current = append(p.current, d)
+	p.mu.Unlock()
+
+	p.stopLoop = make(chan struct{})
+	p.loopDone = make(chan struct{})
+	go p.loop()
+
+	return nil
+}
+
+// Stats returns a snapshot of the pool's internal counters.
+func (p *DriverPool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Stats{
+		Instances:   p.instances.Value(),
+		InFlight:    p.inFlight,
+		SlowWaiters: int(atomic.LoadInt32(&p.slowWaiters)),
+	}
+}
+
+// Execute borrows a Driver from the pool, runs c against it, and returns
+// it to the pool once c returns. It is equivalent to calling ExecuteCtx
+// with context.Background().
+func (p *DriverPool) Execute(c Callback) error {
+	return p.ExecuteCtx(context.Background(), c)
+}
+
+// ExecuteCtx is Execute with a context that can cancel a caller parked
+// waiting for a free driver, either because the pool is at MaxConcurrent
+// or because it cannot grow any further.
+func (p *DriverPool) ExecuteCtx(ctx context.Context, c Callback) error {
+	start := time.Now()
+	defer func() { p.metrics.ObserveExecuteDuration(time.Since(start)) }()
+
+	d, err := p.acquire(ctx)
+	if err != nil {
+		p.metrics.IncExecuteError(errorCause(err))
+		return err
+	}
+	defer p.release(d)
+
+	err = c(d)
+	if err != nil {
+		p.metrics.IncExecuteError("driver_error")
+	}
+
+	return err
+}
+
+// errorCause buckets an error returned by acquire into one of the causes
+// reported through the "pool_closed"/"driver_error"/"timeout" metric
+// label.
+func errorCause(err error) string {
+	switch {
+	case ErrPoolClosed.Is(err), ErrPoolDraining.Is(err):
+		return "pool_closed"
+	case ErrPoolAtCapacity.Is(err):
+		return "timeout"
+	default:
+		return "driver_error"
+	}
+}
+
+// Stop drains the pool with DefaultDrainTimeout and then tears down every
+// remaining driver instance it created.
+func (p *DriverPool) Stop() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDrainTimeout)
+	defer cancel()
+	drainErr := p.Drain(ctx)
+
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.stopLoop)
+	<-p.loopDone
+	p.cond.Broadcast()
+
+	return drainErr
+}
+
+// Drain puts the pool into draining mode: new Execute/ExecuteCtx callers
+// get ErrPoolDraining while callbacks already in flight are left to run
+// to completion. It waits until inFlight reaches zero or ctx is done,
+// whichever happens first, and only then stops every driver instance the
+// pool still holds. If ctx expires first, the callbacks still in flight
+// at that point are interrupted mid-request; Drain reports how many in a
+// wrapped error, but still tears down every driver (including ones that
+// were merely idle) since the pool is shutting down regardless.
+func (p *DriverPool) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+
+	p.draining = true
+	p.cond.Broadcast()
+
+	for p.inFlight > 0 {
+		if !p.condWaitCtx(ctx) {
+			break
+		}
+	}
+
+	killed := p.inFlight
+	p.retireAllLocked()
+	p.mu.Unlock()
+
+	if killed > 0 {
+		return fmt.Errorf("pool drain deadline exceeded: force-stopped %d driver(s) mid-request: %w", killed, ctx.Err())
+	}
+
+	return nil
+}
+
+// acquire returns a Driver ready to be used, blocking on p.cond while the
+// pool is at capacity, until ctx is done or one becomes available.
+func (p *DriverPool) acquire(ctx context.Context) (Driver, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.closed {
+			return nil, ErrPoolClosed
+		}
+
+		if p.draining {
+			return nil, ErrPoolDraining
+		}
+
+		if p.MaxConcurrent > 0 && p.inFlight >= p.MaxConcurrent {
+			if !p.parkLocked(ctx) {
+				return nil, ErrPoolAtCapacity
+			}
+			continue
+		}
+
+		for len(p.idle) > 0 {
+			d := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+
+			if status, err := d.Status(); err != nil || status != libcontainer.Running {
+				p.metrics.IncRestarts()
+				p.retireLocked(d)
+				continue
+			}
+
+			p.inFlight++
+			p.metrics.SetInFlight(p.inFlight)
+			return d, nil
+		}
+
+		target := p.ScalingPolicy.Scale(p.instances.Value(), 1)
+		p.metrics.SetLastScale(target)
+		if target > p.instances.Value() {
+			d, err := p.spawnLocked()
+			if err != nil {
+				return nil, err
+			}
+
+			p.inFlight++
+			p.metrics.SetInFlight(p.inFlight)
+			return d, nil
+		}
+
+		if !p.parkLocked(ctx) {
+			return nil, ErrPoolAtCapacity
+		}
+	}
+}
+
+// release returns d to the idle pool and wakes up any parked callers.
+func (p *DriverPool) release(d Driver) {
+	p.mu.Lock()
+	p.inFlight--
+	p.metrics.SetInFlight(p.inFlight)
+	p.idle = append(p.idle, d)
+	p.mu.Unlock()
+
+	p.cond.Broadcast()
+}
+
+// parkLocked waits on p.cond, which must already be held, until either
+// woken up or ctx is done. It reports whether the caller should retry
+// (true) or give up because ctx expired (false). It also accounts the
+// caller as a slow waiter for the duration of the wait.
+func (p *DriverPool) parkLocked(ctx context.Context) bool {
+	atomic.AddInt32(&p.slowWaiters, 1)
+	defer atomic.AddInt32(&p.slowWaiters, -1)
+
+	return p.condWaitCtx(ctx)
+}
+
+// condWaitCtx waits on p.cond, which must already be held, until either
+// woken up or ctx is done. It reports whether the caller should retry
+// (true) or give up because ctx expired (false).
+func (p *DriverPool) condWaitCtx(ctx context.Context) bool {
+	if ctx.Done() == nil {
+		p.cond.Wait()
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	p.cond.Wait()
+
+	return ctx.Err() == nil
+}
+
+// spawn creates and starts a new driver instance and counts it in
+// p.instances, without adding it to the idle or current lists; Start calls
+// it without p.mu held, spawnLocked with p.mu held.
+func (p *DriverPool) spawn() (Driver, error) {
+	d, err := p.New()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.Start(); err != nil {
+		return nil, err
+	}
+
+	p.instances.add(1)
+	p.metrics.SetInstances(p.instances.Value())
+
+	return d, nil
+}
+
+// spawnLocked is spawn plus bookkeeping that requires p.mu, which must
+// already be held.
+func (p *DriverPool) spawnLocked() (Driver, error) {
+	d, err := p.spawn()
+	if err != nil {
+		return nil, err
+	}
+
+	p.current = append(p.current, d)
+
+	return d, nil
+}
+
+// retireLocked stops d and removes it from the pool's bookkeeping. p.mu
+// must already be held.
+func (p *DriverPool) retireLocked(d Driver) {
+	d.Stop()
+	p.instances.add(-1)
+	p.metrics.SetInstances(p.instances.Value())
+
+	for i, c := range p.current {
+		if c == d {
+			p.current = append(p.current[:i], p.current[i+1:]...)
+			break
+		}
+	}
+}
+
+// retireAllLocked stops and retires every driver instance currently
+// tracked by the pool, decrementing instances and updating metrics
+// accordingly, and reports how many it tore down. p.mu must already be
+// held.
+func (p *DriverPool) retireAllLocked() int {
+	current := p.current
+	p.current = nil
+	p.idle = nil
+
+	for _, d := range current {
+		d.Stop()
+		p.instances.add(-1)
+	}
+	p.metrics.SetInstances(p.instances.Value())
+
+	return len(current)
+}
+
+// loop runs the pool's background maintenance: scaling idle instances
+// down when they are no longer needed, and periodically nudging any slow
+// waiters in case a cond.Broadcast was missed.
+func (p *DriverPool) loop() {
+	defer close(p.loopDone)
+
+	scaleDown := time.NewTicker(scaleDownInterval)
+	defer scaleDown.Stop()
+
+	wakeup := time.NewTicker(wakeupInterval)
+	defer wakeup.Stop()
+
+	for {
+		select {
+		case <-p.stopLoop:
+			return
+		case <-scaleDown.C:
+			p.scaleDown()
+		case <-wakeup.C:
+			p.nudgeWaiters()
+		}
+	}
+}
+
+// scaleDown retires idle driver instances while the scaling policy says
+// the pool can shrink.
+func (p *DriverPool) scaleDown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		target := p.ScalingPolicy.Scale(p.instances.Value(), -1)
+		p.metrics.SetLastScale(target)
+		if target >= p.instances.Value() {
+			return
+		}
+
+		d := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.retireLocked(d)
+	}
+}
+
+// nudgeWaiters broadcasts on p.cond if there are slow waiters and room
+// has freed up for at least one of them, guarding against a missed
+// signal when drivers are returned faster than waiters can be woken.
+func (p *DriverPool) nudgeWaiters() {
+	p.mu.Lock()
+	hasRoom := p.MaxConcurrent == 0 || p.inFlight < p.MaxConcurrent
+	p.mu.Unlock()
+
+	if atomic.LoadInt32(&p.slowWaiters) > 0 && hasRoom {
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
@@ -1,9 +1,11 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -156,6 +158,164 @@ func TestNewDriverPool_Parallel(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestDriverPool_MaxConcurrent_WaiterWakesOnRelease(t *testing.T) {
+	require := require.New(t)
+
+	dp := NewDriverPool(newMockDriver)
+	dp.MaxConcurrent = 1
+	require.NoError(dp.Start())
+
+	release := make(chan struct{})
+	go func() {
+		dp.Execute(func(Driver) error {
+			<-release
+			return nil
+		})
+	}()
+
+	require.Eventually(func() bool {
+		return dp.Stats().InFlight == 1
+	}, time.Second, 5*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := dp.Execute(func(Driver) error { return nil })
+		require.NoError(err)
+	}()
+
+	require.Eventually(func() bool {
+		return dp.Stats().SlowWaiters == 1
+	}, time.Second, 5*time.Millisecond)
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken up after the driver was released")
+	}
+
+	require.Equal(0, dp.Stats().SlowWaiters)
+	require.NoError(dp.Stop())
+}
+
+func TestDriverPool_ExecuteCtx_CancelUnblocksWaiterWithoutLeaking(t *testing.T) {
+	require := require.New(t)
+
+	dp := NewDriverPool(newMockDriver)
+	dp.MaxConcurrent = 1
+	require.NoError(dp.Start())
+
+	block := make(chan struct{})
+	go dp.Execute(func(Driver) error {
+		<-block
+		return nil
+	})
+
+	require.Eventually(func() bool {
+		return dp.Stats().InFlight == 1
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := dp.ExecuteCtx(ctx, func(Driver) error { return nil })
+	require.True(ErrPoolAtCapacity.Is(err))
+	require.Equal(0, dp.Stats().SlowWaiters)
+	require.Equal(1, dp.instances.Value())
+
+	close(block)
+	require.NoError(dp.Stop())
+}
+
+func TestDriverPool_Drain_WaitsForInFlight(t *testing.T) {
+	require := require.New(t)
+
+	var mu sync.Mutex
+	var drivers []*mockDriver
+	newTracked := func() (Driver, error) {
+		d := &mockDriver{MockStatus: libcontainer.Running}
+		mu.Lock()
+		drivers = append(drivers, d)
+		mu.Unlock()
+		return d, nil
+	}
+
+	const n = 5
+
+	dp := NewDriverPool(newTracked)
+	dp.MaxConcurrent = n
+	// The default ScalingPolicy caps instances at runtime.NumCPU(), which
+	// can be smaller than n on a small CI runner; raise the ceiling so the
+	// pool can actually grow to n concurrent drivers regardless of host.
+	dp.ScalingPolicy = MinMax(1, n, AIMD(1, 0.5))
+	require.NoError(dp.Start())
+
+	var completed int32
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			err := dp.Execute(func(Driver) error {
+				time.Sleep(200 * time.Millisecond)
+				atomic.AddInt32(&completed, 1)
+				return nil
+			})
+			require.NoError(err)
+		}()
+	}
+
+	require.Eventually(func() bool {
+		return dp.Stats().InFlight == n
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(dp.Drain(ctx))
+
+	// Every callback must have completed before Drain returned...
+	require.EqualValues(n, atomic.LoadInt32(&completed))
+
+	// ...and only then should Drain have torn down the drivers that ran
+	// them.
+	mu.Lock()
+	for _, d := range drivers {
+		require.Equal(1, d.CalledClose)
+	}
+	mu.Unlock()
+
+	wg.Wait()
+	require.NoError(dp.Stop())
+}
+
+func TestDriverPool_Drain_DeadlineForcesShutdown(t *testing.T) {
+	require := require.New(t)
+
+	dp := NewDriverPool(newMockDriver)
+	require.NoError(dp.Start())
+
+	block := make(chan struct{})
+	go dp.Execute(func(Driver) error {
+		<-block
+		return nil
+	})
+
+	require.Eventually(func() bool {
+		return dp.Stats().InFlight == 1
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := dp.Drain(ctx)
+	require.Error(err)
+	require.Equal(0, dp.instances.Value())
+
+	close(block)
+}
+
 type mockScalingPolicy struct {
 	Total, Load int
 	Result      int
@@ -216,6 +376,72 @@ func TestMovingAverage(t *testing.T) {
 	require.Equal(150, m.Load)
 }
 
+func TestEWMA(t *testing.T) {
+	require := require.New(t)
+
+	m := &mockScalingPolicy{}
+	p := EWMA(1, m)
+	p.Scale(1, 10)
+	require.Equal(10, m.Load)
+	p.Scale(1, 0)
+	require.Equal(0, m.Load)
+
+	m = &mockScalingPolicy{}
+	p = EWMA(0.5, m)
+	p.Scale(1, 10)
+	require.Equal(10, m.Load)
+	p.Scale(1, 2)
+	require.Equal(6, m.Load)
+	p.Scale(1, 6)
+	require.Equal(6, m.Load)
+}
+
+func TestEWMA_Convergence(t *testing.T) {
+	require := require.New(t)
+
+	m := &mockScalingPolicy{}
+	p := EWMA(0.3, m)
+
+	p.Scale(1, 0) // seed away from the step target
+
+	for i := 0; i < 50; i++ {
+		p.Scale(1, 100)
+	}
+
+	require.InDelta(100, m.Load, 1)
+}
+
+func TestPID(t *testing.T) {
+	require := require.New(t)
+
+	p := PID(1, 0, 0, 10)
+	require.Equal(5, p.Scale(0, 15))
+	require.Equal(0, p.Scale(0, 8))
+
+	p = PID(0, 1, 0, 10)
+	require.Equal(6, p.Scale(3, 13))
+	require.Equal(9, p.Scale(3, 13))
+
+	p = PID(0, 0, 1, 10)
+	require.Equal(3, p.Scale(3, 13))
+	require.Equal(3, p.Scale(3, 13))
+	require.Equal(0, p.Scale(3, 8))
+}
+
+func TestPID_Convergence(t *testing.T) {
+	require := require.New(t)
+
+	p := PID(0.5, 0.1, 0, 0)
+
+	demand := 50
+	total := 0
+	for i := 0; i < 30; i++ {
+		total = p.Scale(total, demand-total)
+	}
+
+	require.InDelta(demand, total, 2)
+}
+
 func TestAIMD(t *testing.T) {
 	require := require.New(t)
 
@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"math"
+	"sync"
+)
+
+// ScalingPolicy computes the desired number of driver instances for a
+// DriverPool given the current total and an observed load sample. total
+// and load are opaque to the policy; DriverPool decides what they mean
+// (e.g. total instances and +1/-1 demand pressure).
+type ScalingPolicy interface {
+	Scale(total, load int) int
+}
+
+type minMax struct {
+	min, max int
+	inner    ScalingPolicy
+}
+
+// MinMax wraps inner and clamps its output to the [min, max] range.
+func MinMax(min, max int, inner ScalingPolicy) ScalingPolicy {
+	return &minMax{min: min, max: max, inner: inner}
+}
+
+func (p *minMax) Scale(total, load int) int {
+	v := p.inner.Scale(total, load)
+	switch {
+	case v < p.min:
+		return p.min
+	case v > p.max:
+		return p.max
+	default:
+		return v
+	}
+}
+
+type movingAverage struct {
+	window int
+	buf    []int
+	idx    int
+	count  int
+	sum    int
+	inner  ScalingPolicy
+}
+
+// MovingAverage wraps inner and feeds it the average of the last window
+// load samples instead of the raw, possibly noisy, instantaneous load.
+func MovingAverage(window int, inner ScalingPolicy) ScalingPolicy {
+	return &movingAverage{window: window, buf: make([]int, window), inner: inner}
+}
+
+func (p *movingAverage) Scale(total, load int) int {
+	if p.count < p.window {
+		p.buf[p.idx] = load
+		p.sum += load
+		p.count++
+	} else {
+		p.sum += load - p.buf[p.idx]
+		p.buf[p.idx] = load
+	}
+
+	p.idx = (p.idx + 1) % p.window
+
+	return p.inner.Scale(total, p.sum/p.count)
+}
+
+type aimd struct {
+	inc int
+	dec float64
+}
+
+// AIMD is a ScalingPolicy implementing additive-increase/multiplicative
+// decrease: total grows by inc while load is positive and shrinks by a
+// factor of dec while load is negative, leaving it unchanged otherwise.
+func AIMD(inc int, dec float64) ScalingPolicy {
+	return &aimd{inc: inc, dec: dec}
+}
+
+func (p *aimd) Scale(total, load int) int {
+	switch {
+	case load > 0:
+		return total + p.inc
+	case load < 0:
+		return int(float64(total) * (1 - p.dec))
+	default:
+		return total
+	}
+}
+
+type ewma struct {
+	mu     sync.Mutex
+	alpha  float64
+	state  float64
+	seeded bool
+	inner  ScalingPolicy
+}
+
+// EWMA wraps inner and feeds it an exponentially weighted moving average
+// of the load samples it sees: s_t = alpha*load + (1-alpha)*s_{t-1},
+// seeded with the first observed load. Unlike MovingAverage it needs O(1)
+// state instead of a ring buffer, and reacts faster to a sustained shift
+// in load while still damping brief spikes.
+func EWMA(alpha float64, inner ScalingPolicy) ScalingPolicy {
+	return &ewma{alpha: alpha, inner: inner}
+}
+
+func (p *ewma) Scale(total, load int) int {
+	p.mu.Lock()
+	if !p.seeded {
+		p.state = float64(load)
+		p.seeded = true
+	} else {
+		p.state = p.alpha*float64(load) + (1-p.alpha)*p.state
+	}
+	smoothed := int(math.Round(p.state))
+	p.mu.Unlock()
+
+	return p.inner.Scale(total, smoothed)
+}
+
+// pidIntegralClamp bounds the accumulated integral term of a PID policy,
+// so a long stretch of saturated load can't wind it up so far that the
+// pool overshoots once load returns to target.
+const pidIntegralClamp = 1e6
+
+type pid struct {
+	mu         sync.Mutex
+	kp, ki, kd float64
+	target     int
+	integral   float64
+	prevErr    float64
+	hasPrevErr bool
+}
+
+// PID returns a ScalingPolicy that drives the pool toward target using a
+// proportional-integral-derivative controller over load: each tick it
+// treats load-target as the error signal, integrates it (clamped to
+// +/-pidIntegralClamp to avoid windup), computes the derivative against
+// the previous error, and returns total adjusted by
+// round(kp*e + ki*integral + kd*(e-ePrev)), clamped to non-negative.
+func PID(kp, ki, kd float64, target int) ScalingPolicy {
+	return &pid{kp: kp, ki: ki, kd: kd, target: target}
+}
+
+func (p *pid) Scale(total, load int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := float64(load - p.target)
+
+	p.integral += e
+	if p.integral > pidIntegralClamp {
+		p.integral = pidIntegralClamp
+	} else if p.integral < -pidIntegralClamp {
+		p.integral = -pidIntegralClamp
+	}
+
+	var derivative float64
+	if p.hasPrevErr {
+		derivative = e - p.prevErr
+	}
+	p.prevErr = e
+	p.hasPrevErr = true
+
+	out := total + int(math.Round(p.kp*e+p.ki*p.integral+p.kd*derivative))
+	if out < 0 {
+		return 0
+	}
+
+	return out
+}